@@ -0,0 +1,90 @@
+/*
+Copyright 2020 k0s authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package v1beta1
+
+import (
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterConfig is the CRD that holds the cluster wide configuration
+type ClusterConfig struct {
+	v1.TypeMeta   `json:",inline"`
+	v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec *ClusterSpec `json:"spec,omitempty"`
+}
+
+// ClusterSpec defines the desired state of the whole k0s cluster
+type ClusterSpec struct {
+	API *APISpec `json:"api,omitempty"`
+}
+
+// APISpec defines the settings for the K0s API
+type APISpec struct {
+	// Address on which to listen for the API requests
+	Address string `json:"address,omitempty"`
+
+	// ExternalAddress is the address that's reachable from the outside (e.g. load balancer VIP or DNS name)
+	ExternalAddress string `json:"externalAddress,omitempty"`
+
+	// Port defines the port to listen for the API requests
+	Port int `json:"port,omitempty"`
+
+	// IPFamilies restricts which resolved address families for
+	// ExternalAddress are published, e.g. []string{"IPv4"} or
+	// []string{"IPv4", "IPv6"}. Addresses resolved for any other family are
+	// dropped. When unset, only IPv4 is published under the SingleStack
+	// policy, and both families under the dual-stack policies.
+	IPFamilies []string `json:"ipFamilies,omitempty"`
+
+	// IPFamilyPolicy controls whether a single or both address families are
+	// required to be resolvable. Defaults to SingleStack.
+	IPFamilyPolicy IPFamilyPolicy `json:"ipFamilyPolicy,omitempty"`
+
+	// LoadBalancerProvider is the path to a binary that registers/deregisters
+	// controller node addresses with an external load balancer fronting the
+	// API. Leave empty to disable external LB member management.
+	LoadBalancerProvider string `json:"loadBalancerProvider,omitempty"`
+
+	// ResolverUpstream is the DNS server (host:port) to query when resolving
+	// ExternalAddress. Leave empty to use the host's own resolver, in which
+	// case record TTLs cannot be honored and a conservative default is used.
+	ResolverUpstream string `json:"resolverUpstream,omitempty"`
+
+	// MinResolveInterval is the minimum time to wait between DNS lookups for
+	// ExternalAddress, as a duration string (e.g. "5s"). Acts as a floor on
+	// top of the resolved record's TTL. Defaults to 5s.
+	MinResolveInterval string `json:"minResolveInterval,omitempty"`
+
+	// DebounceResolves is how many consecutive lookups must agree on a new
+	// address set before it replaces the currently published one. Defaults
+	// to 2.
+	DebounceResolves int `json:"debounceResolves,omitempty"`
+}
+
+// IPFamilyPolicy describes how strictly the configured IPFamilies must
+// resolve before the API endpoint is considered usable.
+type IPFamilyPolicy string
+
+const (
+	// IPFamilyPolicySingleStack requires exactly one address family to resolve.
+	IPFamilyPolicySingleStack IPFamilyPolicy = "SingleStack"
+	// IPFamilyPolicyPreferDualStack resolves both families when possible, but
+	// tolerates one of them being unresolvable.
+	IPFamilyPolicyPreferDualStack IPFamilyPolicy = "PreferDualStack"
+	// IPFamilyPolicyRequireDualStack requires both IPv4 and IPv6 to resolve.
+	IPFamilyPolicyRequireDualStack IPFamilyPolicy = "RequireDualStack"
+)
@@ -0,0 +1,28 @@
+/*
+Copyright 2020 k0s authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package component defines the lifecycle interface shared by the pieces
+// that make up a running k0s controller.
+package component
+
+// Component defines the lifecycle methods every controller-side component
+// must implement so it can be managed uniformly by the component manager.
+type Component interface {
+	Init() error
+	Run() error
+	Stop() error
+	Healthy() error
+}
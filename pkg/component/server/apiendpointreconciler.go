@@ -0,0 +1,670 @@
+/*
+Copyright 2020 k0s authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/k0sproject/k0s/pkg/apis/v1beta1"
+	kubeutil "github.com/k0sproject/k0s/pkg/kubernetes"
+	"github.com/k0sproject/k0s/pkg/leaderelection"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// endpointSliceName is the name of the EndpointSlice we maintain
+	// alongside the legacy `kubernetes` Endpoints object.
+	endpointSliceName = "kubernetes"
+
+	// endpointSliceManagedBy identifies k0s as the controller owning the
+	// `kubernetes` EndpointSlice, per the discovery.k8s.io conventions.
+	endpointSliceManagedBy = "k0s-endpoint-reconciler"
+)
+
+// resyncPeriod is the informer's full-resync interval. It's a safety net on
+// top of the watch, not the primary trigger for reconciliation.
+const resyncPeriod = 10 * time.Minute
+
+// syncKey is the single workqueue item we ever enqueue: every reconcile
+// converges the same `kubernetes` Endpoints/EndpointSlice objects, so there's
+// no need to key on the object that triggered the event.
+const syncKey = "sync"
+
+// EndpointReconciler maintains the `kubernetes` Endpoints object in the
+// `default` namespace so that it always points at the currently resolvable
+// set of addresses for the API server, as seen from inside the cluster.
+type EndpointReconciler struct {
+	log *logrus.Entry
+
+	clusterConfig     *v1beta1.ClusterConfig
+	leaderElector     leaderelection.Interface
+	kubeClientFactory kubeutil.ClientFactoryInterface
+	prober            Prober
+	lbProvider        LoadBalancerProvider
+	resolver          addressResolver
+
+	informerFactory informers.SharedInformerFactory
+	workqueue       workqueue.RateLimitingInterface
+
+	wasLeader       bool
+	probedAddresses map[string]bool
+	lastHealthy     resolvedAddresses
+}
+
+// NewEndpointReconciler creates a new EndpointReconciler. The
+// LoadBalancerProvider is selected from clusterConfig: an
+// execLoadBalancerProvider when APISpec.LoadBalancerProvider names a binary,
+// a noopLoadBalancerProvider otherwise.
+func NewEndpointReconciler(clusterConfig *v1beta1.ClusterConfig, leaderElector leaderelection.Interface, kubeClientFactory kubeutil.ClientFactoryInterface, prober Prober) *EndpointReconciler {
+	minResolveInterval, err := time.ParseDuration(clusterConfig.Spec.API.MinResolveInterval)
+	if err != nil {
+		minResolveInterval = 0 // newDNSResolver applies its own default
+	}
+
+	var lbProvider LoadBalancerProvider
+	if binary := clusterConfig.Spec.API.LoadBalancerProvider; binary != "" {
+		lbProvider = NewExecLoadBalancerProvider(binary)
+	} else {
+		lbProvider = NewNoopLoadBalancerProvider()
+	}
+
+	return &EndpointReconciler{
+		log:               logrus.WithField("component", "endpointreconciler"),
+		clusterConfig:     clusterConfig,
+		leaderElector:     leaderElector,
+		kubeClientFactory: kubeClientFactory,
+		prober:            prober,
+		lbProvider:        lbProvider,
+		resolver: newDNSResolver(
+			clusterConfig.Spec.API.ResolverUpstream,
+			minResolveInterval,
+			clusterConfig.Spec.API.DebounceResolves,
+		),
+		workqueue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+}
+
+// Init validates that the configured IPFamilyPolicy can actually be
+// satisfied by resolving ExternalAddress, and builds the informers/workqueue
+// that Run will later start.
+func (e *EndpointReconciler) Init() error {
+	resolved, err := e.resolveAddresses(context.Background())
+	if err != nil {
+		return err
+	}
+
+	wantV4, wantV6 := e.requiredFamilies()
+	if wantV4 && len(resolved.v4) == 0 {
+		return fmt.Errorf("IPv4 is required but %s did not resolve to any IPv4 address", e.externalAddress())
+	}
+	if wantV6 && len(resolved.v6) == 0 {
+		return fmt.Errorf("IPv6 is required but %s did not resolve to any IPv6 address", e.externalAddress())
+	}
+
+	client, err := e.kubeClientFactory.Create()
+	if err != nil {
+		return err
+	}
+
+	e.informerFactory = informers.NewSharedInformerFactoryWithOptions(client, resyncPeriod, informers.WithNamespace("default"))
+	enqueueSync := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { e.workqueue.Add(syncKey) },
+		UpdateFunc: func(interface{}, interface{}) { e.workqueue.Add(syncKey) },
+		DeleteFunc: func(interface{}) { e.workqueue.Add(syncKey) },
+	}
+	if _, err := e.informerFactory.Core().V1().Endpoints().Informer().AddEventHandler(enqueueSync); err != nil {
+		return err
+	}
+	if _, err := e.informerFactory.Discovery().V1().EndpointSlices().Informer().AddEventHandler(enqueueSync); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// requiredFamilies reports which address families must resolve to at least
+// one address for the configured IPFamilies/IPFamilyPolicy to be satisfiable.
+// PreferDualStack never requires a family, since it's allowed to fall back
+// to whatever resolves.
+func (e *EndpointReconciler) requiredFamilies() (v4, v6 bool) {
+	if e.ipFamilyPolicy() == v1beta1.IPFamilyPolicyPreferDualStack {
+		return false, false
+	}
+
+	families := e.clusterConfig.Spec.API.IPFamilies
+	if len(families) == 0 {
+		return true, e.ipFamilyPolicy() == v1beta1.IPFamilyPolicyRequireDualStack
+	}
+
+	return parseFamilies(families)
+}
+
+// allowedFamilies reports which address families may be published at all,
+// regardless of whether they're required. Resolved addresses for any other
+// family are dropped before they reach the Endpoints/EndpointSlice objects.
+func (e *EndpointReconciler) allowedFamilies() (v4, v6 bool) {
+	if families := e.clusterConfig.Spec.API.IPFamilies; len(families) > 0 {
+		return parseFamilies(families)
+	}
+
+	if e.ipFamilyPolicy() == v1beta1.IPFamilyPolicySingleStack {
+		return true, false
+	}
+
+	return true, true
+}
+
+// parseFamilies turns the configured IPFamilies strings into the two
+// booleans every family check here is expressed in terms of.
+func parseFamilies(families []string) (v4, v6 bool) {
+	for _, family := range families {
+		switch family {
+		case "IPv4":
+			v4 = true
+		case "IPv6":
+			v6 = true
+		}
+	}
+	return v4, v6
+}
+
+// ipFamilyPolicy returns the configured IPFamilyPolicy, defaulting to
+// SingleStack.
+func (e *EndpointReconciler) ipFamilyPolicy() v1beta1.IPFamilyPolicy {
+	policy := e.clusterConfig.Spec.API.IPFamilyPolicy
+	if policy == "" {
+		return v1beta1.IPFamilyPolicySingleStack
+	}
+	return policy
+}
+
+// externalAddress returns the configured external address, falling back to
+// the listen address when unset.
+func (e *EndpointReconciler) externalAddress() string {
+	if e.clusterConfig.Spec.API.ExternalAddress != "" {
+		return e.clusterConfig.Spec.API.ExternalAddress
+	}
+	return e.clusterConfig.Spec.API.Address
+}
+
+// Run starts the informers built in Init, waits for their caches to sync,
+// queues an initial sync, and starts a worker goroutine that processes the
+// workqueue until ctx is done. It returns once that worker is started.
+// Unlike component.Component.Run, this takes an explicit ctx: the
+// watch-driven workqueue worker it spawns needs a cancellation signal to
+// stop by, and widening the shared Component interface to require one from
+// every component is a larger, separate change.
+func (e *EndpointReconciler) Run(ctx context.Context) error {
+	e.informerFactory.Start(ctx.Done())
+
+	synced := e.informerFactory.WaitForCacheSync(ctx.Done())
+	for informerType, ok := range synced {
+		if !ok {
+			return fmt.Errorf("failed to sync informer cache for %v", informerType)
+		}
+	}
+
+	// Nothing has necessarily fired an Add event yet (e.g. the Endpoints
+	// object may not exist), so queue one reconcile to converge on startup.
+	e.workqueue.Add(syncKey)
+
+	go e.runWorker(ctx)
+
+	return nil
+}
+
+// runWorker drains the workqueue, reconciling once per item, until ctx is
+// cancelled and the queue is shut down.
+func (e *EndpointReconciler) runWorker(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		e.workqueue.ShutDown()
+	}()
+
+	for e.processNextWorkItem() {
+	}
+}
+
+func (e *EndpointReconciler) processNextWorkItem() bool {
+	key, shutdown := e.workqueue.Get()
+	if shutdown {
+		return false
+	}
+	defer e.workqueue.Done(key)
+
+	if err := e.reconcileEndpoints(); err != nil {
+		e.log.WithError(err).Error("failed to reconcile kubernetes endpoints, will retry")
+		e.workqueue.AddRateLimited(key)
+		return true
+	}
+
+	e.workqueue.Forget(key)
+	return true
+}
+
+// Stop deregisters this node from the external load balancer and removes
+// the EndpointSlices we own, but only if we were the leader: a non-leader
+// replica never owns the `kubernetes`/`kubernetes-ipv6` objects, so it must
+// not delete them out from under the actual leader on its way down. The
+// workqueue itself is shut down by Run's context cancellation.
+func (e *EndpointReconciler) Stop() error {
+	if !e.wasLeader {
+		return nil
+	}
+
+	client, err := e.kubeClientFactory.Create()
+	if err != nil {
+		return err
+	}
+
+	if err := e.deleteEndpointSlices(client); err != nil {
+		return err
+	}
+
+	return e.lbProvider.RemoveMembers(context.TODO(), e.externalAddress(), e.ownMembers())
+}
+
+// deleteEndpointSlices removes both the IPv4 and IPv6 `kubernetes`
+// EndpointSlices, tolerating either already being gone.
+func (e *EndpointReconciler) deleteEndpointSlices(client kubernetes.Interface) error {
+	if err := e.deleteEndpointSlice(client, endpointSliceNameFor(discoveryv1.AddressTypeIPv4)); err != nil {
+		return err
+	}
+	return e.deleteEndpointSlice(client, endpointSliceNameFor(discoveryv1.AddressTypeIPv6))
+}
+
+// Healthy is a no-op health-check for the reconciler
+func (e *EndpointReconciler) Healthy() error {
+	return nil
+}
+
+// reconcileEndpoints resolves the configured external address and makes
+// sure the `kubernetes` Endpoints object in the `default` namespace
+// reflects it. When not the leader it publishes nothing; on the first pass
+// after losing leadership it deletes the EndpointSlices we own and removes
+// this node from the external load balancer.
+func (e *EndpointReconciler) reconcileEndpoints() error {
+	if !e.leaderElector.IsLeader() {
+		if e.wasLeader {
+			e.wasLeader = false
+			client, err := e.kubeClientFactory.Create()
+			if err != nil {
+				e.log.WithError(err).Error("failed to create client to delete endpointslices after losing leadership")
+			} else if err := e.deleteEndpointSlices(client); err != nil {
+				e.log.WithError(err).Error("failed to delete endpointslices after losing leadership")
+			}
+			if err := e.lbProvider.RemoveMembers(context.TODO(), e.externalAddress(), e.ownMembers()); err != nil {
+				e.log.WithError(err).Error("failed to remove load balancer members after losing leadership")
+			}
+		}
+		e.log.Debug("not leader, skipping endpoint reconciliation")
+		return nil
+	}
+	e.wasLeader = true
+
+	resolved, err := e.resolveAddresses(context.TODO())
+	if err != nil {
+		return err
+	}
+
+	healthy := e.probeAddresses(context.TODO(), resolved)
+	published := e.preserveUnhealthyFamilies(resolved, healthy)
+	if len(published.v4) == 0 && len(published.v6) == 0 {
+		e.log.Warn("all candidate addresses failed their health probe, leaving existing endpoints untouched")
+		return nil
+	}
+	e.lastHealthy = published
+
+	client, err := e.kubeClientFactory.Create()
+	if err != nil {
+		return err
+	}
+
+	if err := e.updateEndpoints(client, published); err != nil {
+		return err
+	}
+
+	if err := e.updateEndpointSlices(client, published); err != nil {
+		return err
+	}
+
+	return e.lbProvider.EnsureMembers(context.TODO(), e.externalAddress(), e.ownMembers())
+}
+
+// preserveUnhealthyFamilies guards against a transient probe flap on one
+// address family wiping out that family's published state. A family only
+// falls back to its last published addresses when it had candidates that
+// all failed their probe; a family with no candidates at all (e.g. an
+// IPv6-less SingleStack cluster) is left empty, as always.
+func (e *EndpointReconciler) preserveUnhealthyFamilies(resolved, healthy resolvedAddresses) resolvedAddresses {
+	published := healthy
+	if len(healthy.v4) == 0 && len(resolved.v4) > 0 {
+		published.v4 = e.lastHealthy.v4
+		e.log.Warn("all IPv4 candidates failed their health probe, leaving previously published IPv4 addresses untouched")
+	}
+	if len(healthy.v6) == 0 && len(resolved.v6) > 0 {
+		published.v6 = e.lastHealthy.v6
+		e.log.Warn("all IPv6 candidates failed their health probe, leaving previously published IPv6 addresses untouched")
+	}
+	return published
+}
+
+// ownMembers returns the addresses of this controller node as they should be
+// registered with the external load balancer.
+func (e *EndpointReconciler) ownMembers() []string {
+	return []string{e.clusterConfig.Spec.API.Address}
+}
+
+// probeAddresses filters resolved down to the addresses that pass the
+// configured Prober, recording the outcome of each probe as a gauge so
+// blackholed or stale DNS entries are visible without digging through logs.
+// Labels for addresses that are no longer candidates are dropped in the same
+// pass, so a rotating DNS record (e.g. a CDN cycling its A records) doesn't
+// leave the gauge accumulating one series per address ever seen.
+func (e *EndpointReconciler) probeAddresses(ctx context.Context, resolved resolvedAddresses) resolvedAddresses {
+	port := int32(e.clusterConfig.Spec.API.Port)
+	if port == 0 {
+		port = 6443
+	}
+
+	current := make(map[string]bool, len(resolved.v4)+len(resolved.v6))
+	var healthy resolvedAddresses
+	for _, addresses := range [][]string{resolved.v4, resolved.v6} {
+		for _, address := range addresses {
+			current[address] = true
+			err := e.prober.Probe(ctx, address, port)
+			if err != nil {
+				addressHealthy.WithLabelValues(address).Set(0)
+				e.log.WithError(err).WithField("address", address).Warn("candidate API address failed health probe")
+				continue
+			}
+			addressHealthy.WithLabelValues(address).Set(1)
+			if net.ParseIP(address).To4() != nil {
+				healthy.v4 = append(healthy.v4, address)
+			} else {
+				healthy.v6 = append(healthy.v6, address)
+			}
+		}
+	}
+
+	for address := range e.probedAddresses {
+		if !current[address] {
+			addressHealthy.DeleteLabelValues(address)
+		}
+	}
+	e.probedAddresses = current
+
+	return healthy
+}
+
+// resolvedAddresses holds the resolved addresses for ExternalAddress,
+// already partitioned by address family.
+type resolvedAddresses struct {
+	v4 []string
+	v6 []string
+}
+
+// resolveAddresses resolves the configured external address into IPv4 and
+// IPv6 sets via the cached, debounced dnsResolver, as required for
+// dual-stack clusters where the two families must be published separately.
+// The result is filtered down to the configured IPFamilies/IPFamilyPolicy,
+// so e.g. a SingleStack cluster never publishes an address family it never
+// asked for just because the upstream happens to answer for it too.
+func (e *EndpointReconciler) resolveAddresses(ctx context.Context) (resolvedAddresses, error) {
+	resolved, err := e.resolver.Resolve(ctx, e.externalAddress())
+	if err != nil {
+		return resolvedAddresses{}, err
+	}
+
+	v4, v6 := e.allowedFamilies()
+	if !v4 {
+		resolved.v4 = nil
+	}
+	if !v6 {
+		resolved.v6 = nil
+	}
+	return resolved, nil
+}
+
+// endpointSubsets builds one EndpointSubset per non-empty address family, as
+// legacy Endpoints objects don't carry an address-type field of their own.
+func (e *EndpointReconciler) endpointSubsets(resolved resolvedAddresses) []corev1.EndpointSubset {
+	var subsets []corev1.EndpointSubset
+	for _, addresses := range [][]string{resolved.v4, resolved.v6} {
+		if len(addresses) == 0 {
+			continue
+		}
+		subsets = append(subsets, corev1.EndpointSubset{
+			Addresses: stringsToEndpointAddresses(addresses),
+			Ports:     e.endpointPorts(),
+		})
+	}
+	return subsets
+}
+
+func (e *EndpointReconciler) updateEndpoints(client kubernetes.Interface, resolved resolvedAddresses) error {
+	ctx := context.TODO()
+	epClient := client.CoreV1().Endpoints("default")
+	subsets := e.endpointSubsets(resolved)
+
+	existing, err := epClient.Get(ctx, "kubernetes", metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		ep := &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "kubernetes",
+			},
+			Subsets: subsets,
+		}
+		_, err := epClient.Create(ctx, ep, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if equalSubsets(existing.Subsets, subsets) {
+		e.log.Debug("endpoints already up-to-date, nothing to do")
+		return nil
+	}
+
+	existing.Subsets = subsets
+	_, err = epClient.Update(ctx, existing, metav1.UpdateOptions{})
+	return err
+}
+
+// equalSubsets reports whether two EndpointSubset slices carry the same
+// addresses, subset by subset and in the same order. Ports are not compared.
+func equalSubsets(a, b []corev1.EndpointSubset) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !equalAddresses(endpointAddressesToStrings(a[i].Addresses), endpointAddressesToStrings(b[i].Addresses)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *EndpointReconciler) endpointPorts() []corev1.EndpointPort {
+	port := int32(e.clusterConfig.Spec.API.Port)
+	if port == 0 {
+		port = 6443
+	}
+
+	return []corev1.EndpointPort{
+		{
+			Name:     "https",
+			Port:     port,
+			Protocol: corev1.ProtocolTCP,
+		},
+	}
+}
+
+// endpointSliceNameFor returns the EndpointSlice name for a given address
+// type. IPv4 keeps the historical "kubernetes" name; IPv6 gets a suffixed
+// name since the two must be separate objects in a dual-stack cluster.
+func endpointSliceNameFor(addressType discoveryv1.AddressType) string {
+	if addressType == discoveryv1.AddressTypeIPv6 {
+		return endpointSliceName + "-ipv6"
+	}
+	return endpointSliceName
+}
+
+// updateEndpointSlices keeps one EndpointSlice per non-empty address family
+// in sync with the resolved addresses, since a slice may only carry a
+// single AddressType.
+func (e *EndpointReconciler) updateEndpointSlices(client kubernetes.Interface, resolved resolvedAddresses) error {
+	families := []struct {
+		addressType discoveryv1.AddressType
+		addresses   []string
+	}{
+		{discoveryv1.AddressTypeIPv4, resolved.v4},
+		{discoveryv1.AddressTypeIPv6, resolved.v6},
+	}
+
+	for _, family := range families {
+		if len(family.addresses) == 0 {
+			if err := e.deleteEndpointSlice(client, endpointSliceNameFor(family.addressType)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := e.updateEndpointSlice(client, family.addressType, family.addresses); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *EndpointReconciler) updateEndpointSlice(client kubernetes.Interface, addressType discoveryv1.AddressType, addresses []string) error {
+	ctx := context.TODO()
+	sliceClient := client.DiscoveryV1().EndpointSlices("default")
+	name := endpointSliceNameFor(addressType)
+
+	ready := true
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				discoveryv1.LabelServiceName: "kubernetes",
+				discoveryv1.LabelManagedBy:   endpointSliceManagedBy,
+			},
+		},
+		AddressType: addressType,
+		Endpoints: []discoveryv1.Endpoint{
+			{
+				Addresses:  addresses,
+				Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+			},
+		},
+		Ports: e.endpointSlicePorts(),
+	}
+
+	existing, err := sliceClient.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err := sliceClient.Create(ctx, slice, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if existing.AddressType == slice.AddressType &&
+		len(existing.Endpoints) > 0 &&
+		equalAddresses(existing.Endpoints[0].Addresses, addresses) {
+		e.log.Debug("endpointslice already up-to-date, nothing to do")
+		return nil
+	}
+
+	slice.ObjectMeta = existing.ObjectMeta
+	if slice.Labels == nil {
+		slice.Labels = map[string]string{}
+	}
+	slice.Labels[discoveryv1.LabelServiceName] = "kubernetes"
+	slice.Labels[discoveryv1.LabelManagedBy] = endpointSliceManagedBy
+	_, err = sliceClient.Update(ctx, slice, metav1.UpdateOptions{})
+	return err
+}
+
+// deleteEndpointSlice removes the named EndpointSlice, tolerating it
+// already being gone.
+func (e *EndpointReconciler) deleteEndpointSlice(client kubernetes.Interface, name string) error {
+	err := client.DiscoveryV1().EndpointSlices("default").Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (e *EndpointReconciler) endpointSlicePorts() []discoveryv1.EndpointPort {
+	port := int32(e.clusterConfig.Spec.API.Port)
+	if port == 0 {
+		port = 6443
+	}
+	name := "https"
+	protocol := corev1.ProtocolTCP
+
+	return []discoveryv1.EndpointPort{
+		{
+			Name:     &name,
+			Port:     &port,
+			Protocol: &protocol,
+		},
+	}
+}
+
+func equalAddresses(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func stringsToEndpointAddresses(addresses []string) []corev1.EndpointAddress {
+	epAddresses := make([]corev1.EndpointAddress, len(addresses))
+	for i, address := range addresses {
+		epAddresses[i] = corev1.EndpointAddress{IP: address}
+	}
+
+	return epAddresses
+}
+
+func endpointAddressesToStrings(endpointAddresses []corev1.EndpointAddress) []string {
+	addresses := make([]string, len(endpointAddresses))
+	for i, address := range endpointAddresses {
+		addresses[i] = address.IP
+	}
+
+	return addresses
+}
@@ -17,11 +17,18 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/k0sproject/k0s/pkg/apis/v1beta1"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -52,6 +59,19 @@ func (f *fakeNeverLeaderElector) IsLeader() bool {
 	return false
 }
 
+// fakeProber passes every address unless it's listed in unhealthy, letting
+// tests deterministically simulate probe outcomes without real network I/O.
+type fakeProber struct {
+	unhealthy map[string]bool
+}
+
+func (f *fakeProber) Probe(_ context.Context, address string, _ int32) error {
+	if f.unhealthy[address] {
+		return fmt.Errorf("fake probe failure for %s", address)
+	}
+	return nil
+}
+
 var expectedAddresses = []string{
 	"185.199.108.153",
 	"185.199.109.153",
@@ -80,7 +100,7 @@ func TestBasicReconcilerWithNoLeader(t *testing.T) {
 		},
 	}
 
-	r := NewEndpointReconciler(config, &fakeNeverLeaderElector{}, fakeFactory)
+	r := NewEndpointReconciler(config, &fakeNeverLeaderElector{}, fakeFactory, &fakeProber{})
 
 	assert.NoError(t, r.Init())
 
@@ -107,7 +127,7 @@ func TestBasicReconcilerWithNoExistingEndpoint(t *testing.T) {
 		},
 	}
 
-	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory)
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
 
 	assert.NoError(t, r.Init())
 
@@ -142,7 +162,7 @@ func TestBasicReconcilerWithEmptyEndpointSubset(t *testing.T) {
 		},
 	}
 
-	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory)
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
 
 	assert.NoError(t, r.Init())
 
@@ -185,7 +205,7 @@ func TestReconcilerWithNoNeedForUpdate(t *testing.T) {
 			},
 		},
 	}
-	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory)
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
 
 	assert.NoError(t, r.Init())
 
@@ -203,3 +223,848 @@ func verifyEndpointAddresses(t *testing.T, expectedAddresses []string, fakeFacto
 
 	return ep
 }
+
+func TestNewEndpointReconcilerSelectsLoadBalancerProviderFromConfig(t *testing.T) {
+	fakeFactory := &fakeClientFactory{fakeClient: fake.NewSimpleClientset()}
+
+	noLB := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+	r := NewEndpointReconciler(noLB, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+	assert.IsType(t, &noopLoadBalancerProvider{}, r.lbProvider)
+
+	withLB := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:              "1.2.3.4",
+				ExternalAddress:      "get.k0s.sh",
+				LoadBalancerProvider: "/opt/bin/lb-ctl",
+			},
+		},
+	}
+	r = NewEndpointReconciler(withLB, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+	assert.IsType(t, &execLoadBalancerProvider{}, r.lbProvider)
+}
+
+func TestReconcilerCreatesEndpointSlice(t *testing.T) {
+	var fakeFactory = &fakeClientFactory{
+		fakeClient: fake.NewSimpleClientset(),
+	}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+
+	assert.NoError(t, r.Init())
+	assert.NoError(t, r.reconcileEndpoints())
+
+	slice := verifyEndpointSliceAddresses(t, expectedAddresses, discoveryv1.AddressTypeIPv4, fakeFactory)
+	assert.Equal(t, "kubernetes", slice.Labels[discoveryv1.LabelServiceName])
+	assert.Equal(t, "k0s-endpoint-reconciler", slice.Labels[discoveryv1.LabelManagedBy])
+}
+
+func TestReconcilerEndpointSliceNoopWhenUnchanged(t *testing.T) {
+	var fakeFactory = &fakeClientFactory{
+		fakeClient: fake.NewSimpleClientset(),
+	}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+
+	assert.NoError(t, r.Init())
+	assert.NoError(t, r.reconcileEndpoints())
+
+	fakeClient, _ := fakeFactory.Create()
+	before, err := fakeClient.DiscoveryV1().EndpointSlices("default").Get(context.TODO(), "kubernetes", v1.GetOptions{})
+	assert.NoError(t, err)
+
+	// A second pass over an unchanged address set must not bump the
+	// resource, proving the reconciler took the no-op path.
+	assert.NoError(t, r.reconcileEndpoints())
+	after, err := fakeClient.DiscoveryV1().EndpointSlices("default").Get(context.TODO(), "kubernetes", v1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, before.ResourceVersion, after.ResourceVersion)
+}
+
+func TestReconcilerEndpointSlicePreservesAnnotations(t *testing.T) {
+	var fakeFactory = &fakeClientFactory{
+		fakeClient: fake.NewSimpleClientset(),
+	}
+	existingSlice := discoveryv1.EndpointSlice{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "kubernetes",
+			Annotations: map[string]string{
+				"foo": "bar",
+			},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{
+			{Addresses: expectedAddresses},
+		},
+	}
+	fakeClient, err := fakeFactory.Create()
+	assert.NoError(t, err)
+	_, err = fakeClient.DiscoveryV1().EndpointSlices("default").Create(context.TODO(), &existingSlice, v1.CreateOptions{})
+	assert.NoError(t, err)
+
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+
+	assert.NoError(t, r.Init())
+	assert.NoError(t, r.reconcileEndpoints())
+
+	slice := verifyEndpointSliceAddresses(t, expectedAddresses, discoveryv1.AddressTypeIPv4, fakeFactory)
+	assert.Equal(t, "bar", slice.Annotations["foo"])
+}
+
+func TestReconcilerEndpointSliceUpdatesSliceWithNilLabels(t *testing.T) {
+	var fakeFactory = &fakeClientFactory{
+		fakeClient: fake.NewSimpleClientset(),
+	}
+	existingSlice := discoveryv1.EndpointSlice{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "kubernetes",
+			// Labels is left nil here, as it would be for a slice that
+			// predates this controller or was hand-created without our
+			// labels. The update path must not assume it's initialized.
+		},
+		AddressType: discoveryv1.AddressTypeIPv6,
+		Endpoints: []discoveryv1.Endpoint{
+			{Addresses: []string{"::1"}},
+		},
+	}
+	fakeClient, err := fakeFactory.Create()
+	assert.NoError(t, err)
+	_, err = fakeClient.DiscoveryV1().EndpointSlices("default").Create(context.TODO(), &existingSlice, v1.CreateOptions{})
+	assert.NoError(t, err)
+
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+
+	assert.NoError(t, r.Init())
+	assert.NoError(t, r.reconcileEndpoints())
+
+	slice := verifyEndpointSliceAddresses(t, expectedAddresses, discoveryv1.AddressTypeIPv4, fakeFactory)
+	assert.Equal(t, endpointSliceManagedBy, slice.Labels[discoveryv1.LabelManagedBy])
+	assert.Equal(t, "kubernetes", slice.Labels[discoveryv1.LabelServiceName])
+}
+
+func TestReconcilerEndpointSliceAddressTypeSplitting(t *testing.T) {
+	var fakeFactory = &fakeClientFactory{
+		fakeClient: fake.NewSimpleClientset(),
+	}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+	assert.NoError(t, r.Init())
+	assert.NoError(t, r.reconcileEndpoints())
+
+	// All of the fixture addresses resolved for get.k0s.sh are IPv4, so the
+	// slice must come back typed as such rather than split or left empty.
+	slice := verifyEndpointSliceAddresses(t, expectedAddresses, discoveryv1.AddressTypeIPv4, fakeFactory)
+	assert.Len(t, slice.Endpoints, 1)
+}
+
+func TestReconcilerRequireDualStackFailsInitWhenFamilyMissing(t *testing.T) {
+	var fakeFactory = &fakeClientFactory{
+		fakeClient: fake.NewSimpleClientset(),
+	}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+				IPFamilyPolicy:  v1beta1.IPFamilyPolicyRequireDualStack,
+			},
+		},
+	}
+
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+
+	// get.k0s.sh only resolves to IPv4 addresses, so RequireDualStack can
+	// never be satisfied and Init must fail rather than silently publishing
+	// a single-family endpoint.
+	assert.Error(t, r.Init())
+}
+
+func TestReconcilerSingleStackFailsInitWhenRequestedFamilyMissing(t *testing.T) {
+	var fakeFactory = &fakeClientFactory{
+		fakeClient: fake.NewSimpleClientset(),
+	}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+				IPFamilies:      []string{"IPv6"},
+				IPFamilyPolicy:  v1beta1.IPFamilyPolicySingleStack,
+			},
+		},
+	}
+
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+
+	assert.Error(t, r.Init())
+}
+
+func TestReconcilerSingleStackFiltersOutUnrequestedFamily(t *testing.T) {
+	fakeFactory := &fakeClientFactory{fakeClient: fake.NewSimpleClientset()}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+	assert.NoError(t, r.Init())
+
+	// The default SingleStack policy with no IPFamilies set should only ever
+	// publish IPv4, even if ExternalAddress also happens to resolve an AAAA
+	// record.
+	r.resolver = &fakeResolver{results: []resolvedAddresses{{
+		v4: []string{"10.0.0.1"},
+		v6: []string{"2001:db8::1"},
+	}}}
+
+	assert.NoError(t, r.reconcileEndpoints())
+	verifyEndpointAddresses(t, []string{"10.0.0.1"}, fakeFactory)
+
+	fakeClient, _ := fakeFactory.Create()
+	_, err := fakeClient.DiscoveryV1().EndpointSlices("default").Get(context.TODO(), "kubernetes-ipv6", v1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestReconcilerExplicitSingleFamilyFiltersOutOtherFamily(t *testing.T) {
+	fakeFactory := &fakeClientFactory{fakeClient: fake.NewSimpleClientset()}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+				IPFamilies:      []string{"IPv4"},
+			},
+		},
+	}
+
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+	assert.NoError(t, r.Init())
+
+	// Pinning IPFamilies to IPv4 must filter out AAAA answers too, not just
+	// fail Init if IPv4 is missing.
+	r.resolver = &fakeResolver{results: []resolvedAddresses{{
+		v4: []string{"10.0.0.1"},
+		v6: []string{"2001:db8::1"},
+	}}}
+
+	assert.NoError(t, r.reconcileEndpoints())
+	verifyEndpointAddresses(t, []string{"10.0.0.1"}, fakeFactory)
+
+	fakeClient, _ := fakeFactory.Create()
+	_, err := fakeClient.DiscoveryV1().EndpointSlices("default").Get(context.TODO(), "kubernetes-ipv6", v1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestReconcilerPreferDualStackToleratesPartialResolution(t *testing.T) {
+	var fakeFactory = &fakeClientFactory{
+		fakeClient: fake.NewSimpleClientset(),
+	}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+				IPFamilyPolicy:  v1beta1.IPFamilyPolicyPreferDualStack,
+			},
+		},
+	}
+
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+
+	// get.k0s.sh has no AAAA records, but PreferDualStack only asks for a
+	// best-effort dual-stack set, so Init must still succeed.
+	assert.NoError(t, r.Init())
+	assert.NoError(t, r.reconcileEndpoints())
+
+	verifyEndpointAddresses(t, expectedAddresses, fakeFactory)
+	verifyEndpointSliceAddresses(t, expectedAddresses, discoveryv1.AddressTypeIPv4, fakeFactory)
+
+	fakeClient, _ := fakeFactory.Create()
+	_, err := fakeClient.DiscoveryV1().EndpointSlices("default").Get(context.TODO(), "kubernetes-ipv6", v1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func verifyEndpointSliceAddresses(t *testing.T, expectedAddresses []string, expectedType discoveryv1.AddressType, fakeFactory *fakeClientFactory) *discoveryv1.EndpointSlice {
+	fakeClient, _ := fakeFactory.Create()
+	slice, err := fakeClient.DiscoveryV1().EndpointSlices("default").Get(context.TODO(), "kubernetes", v1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, expectedType, slice.AddressType)
+	assert.Equal(t, expectedAddresses, slice.Endpoints[0].Addresses)
+
+	return slice
+}
+
+func TestReconcilerExcludesUnhealthyAddresses(t *testing.T) {
+	var fakeFactory = &fakeClientFactory{
+		fakeClient: fake.NewSimpleClientset(),
+	}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+	prober := &fakeProber{unhealthy: map[string]bool{
+		expectedAddresses[0]: true,
+		expectedAddresses[1]: true,
+	}}
+
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, prober)
+
+	assert.NoError(t, r.Init())
+	assert.NoError(t, r.reconcileEndpoints())
+
+	verifyEndpointAddresses(t, expectedAddresses[2:], fakeFactory)
+}
+
+func TestReconcilerLeavesEndpointsUntouchedWhenAllAddressesUnhealthy(t *testing.T) {
+	var fakeFactory = &fakeClientFactory{
+		fakeClient: fake.NewSimpleClientset(),
+	}
+	existingEp := corev1.Endpoints{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "kubernetes",
+		},
+		Subsets: []corev1.EndpointSubset{
+			{
+				Addresses: stringsToEndpointAddresses(expectedAddresses),
+			},
+		},
+	}
+	fakeClient, err := fakeFactory.Create()
+	assert.NoError(t, err)
+	_, err = fakeClient.CoreV1().Endpoints("default").Create(context.TODO(), &existingEp, v1.CreateOptions{})
+	assert.NoError(t, err)
+
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+	allUnhealthy := &fakeProber{unhealthy: map[string]bool{
+		expectedAddresses[0]: true,
+		expectedAddresses[1]: true,
+		expectedAddresses[2]: true,
+		expectedAddresses[3]: true,
+	}}
+
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, allUnhealthy)
+
+	assert.NoError(t, r.Init())
+	assert.NoError(t, r.reconcileEndpoints())
+
+	// Every candidate failed its probe, so the reconciler must leave the
+	// previously-published Endpoints object exactly as it found it.
+	verifyEndpointAddresses(t, expectedAddresses, fakeFactory)
+}
+
+func TestReconcilerRecoversAfterAddressHealthIsRestored(t *testing.T) {
+	var fakeFactory = &fakeClientFactory{
+		fakeClient: fake.NewSimpleClientset(),
+	}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+	prober := &fakeProber{unhealthy: map[string]bool{
+		expectedAddresses[0]: true,
+	}}
+
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, prober)
+
+	assert.NoError(t, r.Init())
+	assert.NoError(t, r.reconcileEndpoints())
+	verifyEndpointAddresses(t, expectedAddresses[1:], fakeFactory)
+
+	// Once the previously-failing address starts passing its probe again,
+	// the next reconcile should bring it back into the published set.
+	prober.unhealthy = nil
+	assert.NoError(t, r.reconcileEndpoints())
+	verifyEndpointAddresses(t, expectedAddresses, fakeFactory)
+}
+
+func TestReconcilerPreservesHealthyFamilyWhenOtherFamilyFullyUnhealthy(t *testing.T) {
+	fakeFactory := &fakeClientFactory{fakeClient: fake.NewSimpleClientset()}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+				IPFamilyPolicy:  v1beta1.IPFamilyPolicyPreferDualStack,
+			},
+		},
+	}
+
+	prober := &fakeProber{}
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, prober)
+	assert.NoError(t, r.Init())
+
+	v4Addresses := []string{"10.0.0.1", "10.0.0.2"}
+	v6Addresses := []string{"2001:db8::1"}
+	r.resolver = &fakeResolver{results: []resolvedAddresses{{
+		v4: v4Addresses,
+		v6: v6Addresses,
+	}}}
+
+	assert.NoError(t, r.reconcileEndpoints())
+	verifyEndpointSliceAddresses(t, v4Addresses, discoveryv1.AddressTypeIPv4, fakeFactory)
+	fakeClient, _ := fakeFactory.Create()
+	v6Slice, err := fakeClient.DiscoveryV1().EndpointSlices("default").Get(context.TODO(), "kubernetes-ipv6", v1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, v6Addresses, v6Slice.Endpoints[0].Addresses)
+
+	// Every IPv4 candidate now fails its probe while IPv6 stays healthy. A
+	// transient flap on one family must not wipe out the other family's
+	// reachability info, nor the failed family's own prior state.
+	prober.unhealthy = map[string]bool{
+		v4Addresses[0]: true,
+		v4Addresses[1]: true,
+	}
+	assert.NoError(t, r.reconcileEndpoints())
+
+	verifyEndpointSliceAddresses(t, v4Addresses, discoveryv1.AddressTypeIPv4, fakeFactory)
+	v6Slice, err = fakeClient.DiscoveryV1().EndpointSlices("default").Get(context.TODO(), "kubernetes-ipv6", v1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, v6Addresses, v6Slice.Endpoints[0].Addresses)
+}
+
+// collectAddressHealthyLabels reads back the current set of "address" label
+// values published on the addressHealthy gauge, keyed by their value.
+func collectAddressHealthyLabels(t *testing.T) map[string]float64 {
+	ch := make(chan prometheus.Metric, 100)
+	addressHealthy.Collect(ch)
+	close(ch)
+
+	labels := make(map[string]float64)
+	for m := range ch {
+		var pb dto.Metric
+		assert.NoError(t, m.Write(&pb))
+		for _, l := range pb.GetLabel() {
+			if l.GetName() == "address" {
+				labels[l.GetValue()] = pb.GetGauge().GetValue()
+			}
+		}
+	}
+	return labels
+}
+
+func TestReconcilerPrunesStaleAddressHealthyLabels(t *testing.T) {
+	fakeFactory := &fakeClientFactory{fakeClient: fake.NewSimpleClientset()}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+	assert.NoError(t, r.Init())
+
+	// TEST-NET-3 addresses, unique to this test, so assertions don't depend
+	// on what other tests in this package have left behind in the gauge.
+	r.resolver = &fakeResolver{results: []resolvedAddresses{
+		{v4: []string{"203.0.113.11", "203.0.113.12"}},
+		{v4: []string{"203.0.113.13"}},
+	}}
+
+	assert.NoError(t, r.reconcileEndpoints())
+	labels := collectAddressHealthyLabels(t)
+	assert.Contains(t, labels, "203.0.113.11")
+	assert.Contains(t, labels, "203.0.113.12")
+
+	// The next resolve rotates both addresses out of the candidate set, as a
+	// CDN cycling its A records would. Their gauge labels must be pruned
+	// rather than lingering at their last recorded value forever.
+	assert.NoError(t, r.reconcileEndpoints())
+	labels = collectAddressHealthyLabels(t)
+	assert.NotContains(t, labels, "203.0.113.11")
+	assert.NotContains(t, labels, "203.0.113.12")
+	assert.Contains(t, labels, "203.0.113.13")
+}
+
+func TestReconcilerConvergesOnWatchEventWithoutWaitingForResync(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset()
+	fakeFactory := &fakeClientFactory{fakeClient: fakeClient}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+	assert.NoError(t, r.Init())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, r.Run(ctx))
+
+	// Run queues an initial sync on startup, so the object should appear
+	// without any external trigger.
+	assert.Eventually(t, func() bool {
+		_, err := fakeClient.CoreV1().Endpoints("default").Get(context.TODO(), "kubernetes", v1.GetOptions{})
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Simulate an admin clobbering the object directly; the informer's
+	// update event should re-enqueue a sync and fix it back up, with no
+	// ticker involved.
+	existing, err := fakeClient.CoreV1().Endpoints("default").Get(context.TODO(), "kubernetes", v1.GetOptions{})
+	assert.NoError(t, err)
+	existing.Subsets = []corev1.EndpointSubset{{Addresses: stringsToEndpointAddresses([]string{"10.0.0.1"})}}
+	_, err = fakeClient.CoreV1().Endpoints("default").Update(context.TODO(), existing, v1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		ep, err := fakeClient.CoreV1().Endpoints("default").Get(context.TODO(), "kubernetes", v1.GetOptions{})
+		if err != nil || len(ep.Subsets) == 0 {
+			return false
+		}
+		return assert.ObjectsAreEqual(expectedAddresses, endpointAddressesToStrings(ep.Subsets[0].Addresses))
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+// fakeLoadBalancerProvider records the order and arguments of every
+// Ensure/RemoveMembers call, so tests can assert call ordering relative to
+// leadership changes.
+type fakeLoadBalancerProvider struct {
+	calls []string
+}
+
+func (f *fakeLoadBalancerProvider) EnsureMembers(_ context.Context, vip string, members []string) error {
+	f.calls = append(f.calls, fmt.Sprintf("ensure(%s,%v)", vip, members))
+	return nil
+}
+
+func (f *fakeLoadBalancerProvider) RemoveMembers(_ context.Context, vip string, members []string) error {
+	f.calls = append(f.calls, fmt.Sprintf("remove(%s,%v)", vip, members))
+	return nil
+}
+
+// fakeToggleLeaderElector lets a test flip leadership between reconciles,
+// unlike the always/never fakes above.
+type fakeToggleLeaderElector struct {
+	leader bool
+}
+
+func (f *fakeToggleLeaderElector) Run() error     { return nil }
+func (f *fakeToggleLeaderElector) Init() error    { return nil }
+func (f *fakeToggleLeaderElector) Stop() error    { return nil }
+func (f *fakeToggleLeaderElector) Healthy() error { return nil }
+func (f *fakeToggleLeaderElector) IsLeader() bool { return f.leader }
+
+func TestReconcilerEnsuresLoadBalancerMembersAfterPublishingAsLeader(t *testing.T) {
+	fakeFactory := &fakeClientFactory{fakeClient: fake.NewSimpleClientset()}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "10.0.0.5",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+	lb := &fakeLoadBalancerProvider{}
+
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+	r.lbProvider = lb
+
+	assert.NoError(t, r.Init())
+	assert.NoError(t, r.reconcileEndpoints())
+
+	// EnsureMembers must only be called once the Endpoints/EndpointSlice
+	// write has succeeded, not before.
+	verifyEndpointAddresses(t, expectedAddresses, fakeFactory)
+	assert.Equal(t, []string{"ensure(get.k0s.sh,[10.0.0.5])"}, lb.calls)
+}
+
+func TestReconcilerRemovesLoadBalancerMembersOnLeadershipLoss(t *testing.T) {
+	fakeFactory := &fakeClientFactory{fakeClient: fake.NewSimpleClientset()}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "10.0.0.5",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+	lb := &fakeLoadBalancerProvider{}
+	elector := &fakeToggleLeaderElector{leader: true}
+
+	r := NewEndpointReconciler(config, elector, fakeFactory, &fakeProber{})
+	r.lbProvider = lb
+
+	assert.NoError(t, r.Init())
+	assert.NoError(t, r.reconcileEndpoints())
+
+	elector.leader = false
+	assert.NoError(t, r.reconcileEndpoints())
+
+	// RemoveMembers must fire exactly once, right after EnsureMembers, when
+	// leadership is lost - not on every subsequent non-leader reconcile.
+	assert.NoError(t, r.reconcileEndpoints())
+	assert.Equal(t, []string{"ensure(get.k0s.sh,[10.0.0.5])", "remove(get.k0s.sh,[10.0.0.5])"}, lb.calls)
+}
+
+func TestReconcilerDeletesEndpointSliceOnLeadershipLoss(t *testing.T) {
+	fakeFactory := &fakeClientFactory{fakeClient: fake.NewSimpleClientset()}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "10.0.0.5",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+	elector := &fakeToggleLeaderElector{leader: true}
+
+	r := NewEndpointReconciler(config, elector, fakeFactory, &fakeProber{})
+
+	assert.NoError(t, r.Init())
+	assert.NoError(t, r.reconcileEndpoints())
+	verifyEndpointSliceAddresses(t, expectedAddresses, discoveryv1.AddressTypeIPv4, fakeFactory)
+
+	// Losing leadership while the process keeps running must delete the
+	// EndpointSlice we own, not just leave it for the eventual Stop() on
+	// full shutdown.
+	elector.leader = false
+	assert.NoError(t, r.reconcileEndpoints())
+
+	fakeClient, _ := fakeFactory.Create()
+	_, err := fakeClient.DiscoveryV1().EndpointSlices("default").Get(context.TODO(), "kubernetes", v1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestStopDeletesEndpointSliceWhenLeader(t *testing.T) {
+	fakeFactory := &fakeClientFactory{fakeClient: fake.NewSimpleClientset()}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "10.0.0.5",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+
+	assert.NoError(t, r.Init())
+	assert.NoError(t, r.reconcileEndpoints())
+	verifyEndpointSliceAddresses(t, expectedAddresses, discoveryv1.AddressTypeIPv4, fakeFactory)
+
+	assert.NoError(t, r.Stop())
+
+	fakeClient, _ := fakeFactory.Create()
+	_, err := fakeClient.DiscoveryV1().EndpointSlices("default").Get(context.TODO(), "kubernetes", v1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestStopLeavesEndpointSliceUntouchedWhenNeverLeader(t *testing.T) {
+	fakeFactory := &fakeClientFactory{fakeClient: fake.NewSimpleClientset()}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "10.0.0.5",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+	existingSlice := discoveryv1.EndpointSlice{
+		ObjectMeta:  v1.ObjectMeta{Name: "kubernetes"},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{
+			{Addresses: expectedAddresses},
+		},
+	}
+	fakeClient, err := fakeFactory.Create()
+	assert.NoError(t, err)
+	_, err = fakeClient.DiscoveryV1().EndpointSlices("default").Create(context.TODO(), &existingSlice, v1.CreateOptions{})
+	assert.NoError(t, err)
+
+	// This replica never reconciled as leader, so the EndpointSlice above
+	// belongs to some other, still-running leader. Stop must not touch it.
+	r := NewEndpointReconciler(config, &fakeNeverLeaderElector{}, fakeFactory, &fakeProber{})
+	assert.NoError(t, r.Init())
+
+	assert.NoError(t, r.Stop())
+
+	verifyEndpointSliceAddresses(t, expectedAddresses, discoveryv1.AddressTypeIPv4, fakeFactory)
+}
+
+// fakeResolver replays a fixed sequence of resolutions, letting tests
+// simulate a flapping or shuffled DNS record without real network I/O.
+// Once the sequence is exhausted it keeps returning the last entry.
+type fakeResolver struct {
+	results []resolvedAddresses
+	i       int
+}
+
+func (f *fakeResolver) Resolve(_ context.Context, _ string) (resolvedAddresses, error) {
+	if f.i >= len(f.results) {
+		return f.results[len(f.results)-1], nil
+	}
+	r := f.results[f.i]
+	f.i++
+	return r, nil
+}
+
+func TestReconcilerDoesNotThrashOnFlappingResolutions(t *testing.T) {
+	fakeFactory := &fakeClientFactory{fakeClient: fake.NewSimpleClientset()}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+	assert.NoError(t, r.Init())
+
+	setA := resolvedAddresses{v4: []string{"10.0.0.1", "10.0.0.2"}}
+	setB := resolvedAddresses{v4: []string{"10.0.0.3"}}
+	sequence := []resolvedAddresses{setA, setB, setA, setB, setB}
+
+	// The flapping answers come from a fake upstream DNS server, so they go
+	// through the debouncing dnsResolver instead of replacing it. Each
+	// lookup's A query is answered with the next set in the sequence.
+	var lookups int32
+	upstream := startFakeDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		if req.Question[0].Qtype == dns.TypeA {
+			i := int(atomic.AddInt32(&lookups, 1)) - 1
+			if i >= len(sequence) {
+				i = len(sequence) - 1
+			}
+			for _, address := range sequence[i].v4 {
+				rr, err := dns.NewRR(req.Question[0].Name + " 30 IN A " + address)
+				assert.NoError(t, err)
+				m.Answer = append(m.Answer, rr)
+			}
+		}
+		assert.NoError(t, w.WriteMsg(m))
+	})
+	resolver := newDNSResolver(upstream, 0, 0)
+	r.resolver = resolver
+
+	// nextAllowed is reset before every pass so the TTL cache doesn't skip
+	// the lookup.
+
+	// First successful resolution is published immediately (bootstrap).
+	resolver.nextAllowed = time.Time{}
+	assert.NoError(t, r.reconcileEndpoints())
+	verifyEndpointAddresses(t, setA.v4, fakeFactory)
+
+	// setB, setA, setB each show up once in a row - never twice
+	// consecutively - so the debounced result must stay at setA throughout.
+	resolver.nextAllowed = time.Time{}
+	assert.NoError(t, r.reconcileEndpoints())
+	verifyEndpointAddresses(t, setA.v4, fakeFactory)
+	resolver.nextAllowed = time.Time{}
+	assert.NoError(t, r.reconcileEndpoints())
+	verifyEndpointAddresses(t, setA.v4, fakeFactory)
+	resolver.nextAllowed = time.Time{}
+	assert.NoError(t, r.reconcileEndpoints())
+	verifyEndpointAddresses(t, setA.v4, fakeFactory)
+
+	// setB now repeats for a second consecutive resolution, so it's
+	// confirmed and the published set finally switches.
+	resolver.nextAllowed = time.Time{}
+	assert.NoError(t, r.reconcileEndpoints())
+	verifyEndpointAddresses(t, setB.v4, fakeFactory)
+}
+
+func TestReconcilerBacksOffAfterFailedResolution(t *testing.T) {
+	fakeFactory := &fakeClientFactory{fakeClient: fake.NewSimpleClientset()}
+	config := &v1beta1.ClusterConfig{
+		Spec: &v1beta1.ClusterSpec{
+			API: &v1beta1.APISpec{
+				Address:         "1.2.3.4",
+				ExternalAddress: "get.k0s.sh",
+			},
+		},
+	}
+
+	r := NewEndpointReconciler(config, &fakeAlwaysLeaderElector{}, fakeFactory, &fakeProber{})
+	assert.NoError(t, r.Init())
+
+	resolver := newDNSResolver("", 0, 0)
+	r.resolver = resolver
+
+	assert.NoError(t, r.reconcileEndpoints())
+	verifyEndpointAddresses(t, expectedAddresses, fakeFactory)
+
+	// Force the next lookup to fail by pointing it at an upstream nobody
+	// answers on, and check that the resolver falls back to the last
+	// confirmed result rather than erroring the whole reconcile.
+	resolver.nextAllowed = time.Time{}
+	resolver.upstream = "127.0.0.1:1" // nothing listens here: lookup fails
+	assert.NoError(t, r.reconcileEndpoints())
+	verifyEndpointAddresses(t, expectedAddresses, fakeFactory)
+	assert.True(t, resolver.backoff >= minBackoff)
+}
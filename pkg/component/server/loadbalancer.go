@@ -0,0 +1,99 @@
+/*
+Copyright 2020 k0s authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package server
+
+import (
+	"context"
+	"os/exec"
+)
+
+// LoadBalancerProvider registers and deregisters controller node addresses
+// as members of an external load balancer fronting the API (OpenStack
+// Octavia, MetalLB, an AWS NLB, ...), keyed by the VIP/address the LB
+// exposes to clients.
+type LoadBalancerProvider interface {
+	EnsureMembers(ctx context.Context, vip string, members []string) error
+	RemoveMembers(ctx context.Context, vip string, members []string) error
+}
+
+// noopLoadBalancerProvider is the default LoadBalancerProvider: it does
+// nothing, for the common case where there's no external LB to manage.
+type noopLoadBalancerProvider struct{}
+
+// NewNoopLoadBalancerProvider creates a LoadBalancerProvider that never
+// touches any external load balancer.
+func NewNoopLoadBalancerProvider() LoadBalancerProvider {
+	return &noopLoadBalancerProvider{}
+}
+
+func (*noopLoadBalancerProvider) EnsureMembers(_ context.Context, _ string, _ []string) error {
+	return nil
+}
+
+func (*noopLoadBalancerProvider) RemoveMembers(_ context.Context, _ string, _ []string) error {
+	return nil
+}
+
+// execLoadBalancerProvider registers/deregisters members by shelling out to
+// a provider-specific binary, invoked as:
+//
+//	<binary> ensure-members --vip <vip> --member <member> [--member <member> ...]
+//	<binary> remove-members --vip <vip> --member <member> [--member <member> ...]
+type execLoadBalancerProvider struct {
+	binary string
+}
+
+// NewExecLoadBalancerProvider creates a LoadBalancerProvider that delegates
+// to the given binary, as configured via ClusterConfig.
+func NewExecLoadBalancerProvider(binary string) LoadBalancerProvider {
+	return &execLoadBalancerProvider{binary: binary}
+}
+
+func (p *execLoadBalancerProvider) EnsureMembers(ctx context.Context, vip string, members []string) error {
+	return p.run(ctx, "ensure-members", vip, members)
+}
+
+func (p *execLoadBalancerProvider) RemoveMembers(ctx context.Context, vip string, members []string) error {
+	return p.run(ctx, "remove-members", vip, members)
+}
+
+func (p *execLoadBalancerProvider) run(ctx context.Context, subcommand, vip string, members []string) error {
+	args := []string{subcommand, "--vip", vip}
+	for _, member := range members {
+		args = append(args, "--member", member)
+	}
+
+	cmd := exec.CommandContext(ctx, p.binary, args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return &execLoadBalancerError{subcommand: subcommand, output: out, cause: err}
+	}
+	return nil
+}
+
+type execLoadBalancerError struct {
+	subcommand string
+	output     []byte
+	cause      error
+}
+
+func (e *execLoadBalancerError) Error() string {
+	return "load balancer provider " + e.subcommand + " failed: " + e.cause.Error() + ": " + string(e.output)
+}
+
+func (e *execLoadBalancerError) Unwrap() error {
+	return e.cause
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2020 k0s authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFakeLoadBalancerScript writes a shell script standing in for a real
+// load-balancer provider binary: it echoes its arguments and exits non-zero
+// when invoked with "remove-members", so tests can exercise both the
+// success and failure paths of execLoadBalancerProvider.run.
+func writeFakeLoadBalancerScript(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "lb-ctl")
+	script := "#!/bin/sh\necho \"$@\"\nif [ \"$1\" = \"remove-members\" ]; then\n  echo \"boom\" >&2\n  exit 1\nfi\n"
+	assert.NoError(t, os.WriteFile(path, []byte(script), 0o755))
+	return path
+}
+
+func TestExecLoadBalancerProviderEnsureMembersRunsBinary(t *testing.T) {
+	p := NewExecLoadBalancerProvider(writeFakeLoadBalancerScript(t))
+
+	err := p.EnsureMembers(context.Background(), "10.0.0.1", []string{"10.0.0.2", "10.0.0.3"})
+	assert.NoError(t, err)
+}
+
+func TestExecLoadBalancerProviderRemoveMembersReturnsCommandOutputOnFailure(t *testing.T) {
+	p := NewExecLoadBalancerProvider(writeFakeLoadBalancerScript(t))
+
+	err := p.RemoveMembers(context.Background(), "10.0.0.1", []string{"10.0.0.2"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "remove-members")
+	assert.Contains(t, err.Error(), "boom")
+}
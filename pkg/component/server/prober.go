@@ -0,0 +1,89 @@
+/*
+Copyright 2020 k0s authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// probeTimeout bounds how long a single address probe may take, so a
+// blackholed address can't stall a whole reconcile pass.
+const probeTimeout = 2 * time.Second
+
+// addressHealthy reports, per candidate address, whether the last probe of
+// that address against the API port succeeded.
+var addressHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "k0s_endpoint_reconciler_address_healthy",
+	Help: "Whether the last health probe of a candidate API address succeeded (1) or not (0)",
+}, []string{"address"})
+
+func init() {
+	prometheus.MustRegister(addressHealthy)
+}
+
+// Prober checks whether a candidate API address is actually reachable
+// before the reconciler publishes it in the `kubernetes` Endpoints object.
+type Prober interface {
+	Probe(ctx context.Context, address string, port int32) error
+}
+
+// tcpTLSProber is the default Prober: it dials the API port, completes a
+// TLS handshake, and confirms the API server answers /readyz.
+type tcpTLSProber struct {
+	client *http.Client
+}
+
+// NewProber creates the default Prober implementation.
+func NewProber() Prober {
+	return &tcpTLSProber{
+		client: &http.Client{
+			Timeout: probeTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec // we only care about reachability, trust is established via the kubeconfig
+			},
+		},
+	}
+}
+
+func (p *tcpTLSProber) Probe(ctx context.Context, address string, port int32) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	url := fmt.Sprintf("https://%s/readyz", net.JoinHostPort(address, fmt.Sprintf("%d", port)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("probe of %s returned status %d", address, resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2020 k0s authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultMinResolveInterval is the floor used when APISpec doesn't
+	// configure one.
+	defaultMinResolveInterval = 5 * time.Second
+
+	// defaultDebounceResolves is how many consecutive lookups must agree on
+	// a changed address set before it's published, absent configuration.
+	defaultDebounceResolves = 2
+
+	// fallbackTTL is used when resolving via net.Resolver, which doesn't
+	// expose record TTLs.
+	fallbackTTL = 30 * time.Second
+
+	// minBackoff/maxBackoff bound the exponential backoff applied after a
+	// failed lookup (NXDOMAIN/SERVFAIL/timeout), so a flapping upstream
+	// can't be hammered with retries.
+	minBackoff = 1 * time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+// addressResolver resolves a host into its current address set. It exists
+// so tests can substitute a deterministic fake for dnsResolver.
+type addressResolver interface {
+	Resolve(ctx context.Context, host string) (resolvedAddresses, error)
+}
+
+// dnsResolver resolves ExternalAddress with TTL-aware caching, exponential
+// backoff on failure, and debouncing so a flapping record (e.g. a CDN
+// rotating A records) doesn't thrash the published endpoint set.
+type dnsResolver struct {
+	upstream         string
+	minInterval      time.Duration
+	debounceResolves int
+
+	mu            sync.Mutex
+	nextAllowed   time.Time
+	backoff       time.Duration
+	haveConfirmed bool
+	confirmed     resolvedAddresses
+	pending       resolvedAddresses
+	pendingStreak int
+}
+
+// newDNSResolver creates a dnsResolver. An empty upstream falls back to the
+// host's own resolver.
+func newDNSResolver(upstream string, minInterval time.Duration, debounceResolves int) *dnsResolver {
+	if minInterval <= 0 {
+		minInterval = defaultMinResolveInterval
+	}
+	if debounceResolves <= 0 {
+		debounceResolves = defaultDebounceResolves
+	}
+	return &dnsResolver{
+		upstream:         upstream,
+		minInterval:      minInterval,
+		debounceResolves: debounceResolves,
+	}
+}
+
+// Resolve returns the debounced, TTL-cached address set for host. While
+// backing off after a failed lookup, or before the cached TTL has expired,
+// it returns the last confirmed result without querying DNS again.
+func (r *dnsResolver) Resolve(ctx context.Context, host string) (resolvedAddresses, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(r.nextAllowed) {
+		if r.haveConfirmed {
+			return r.confirmed, nil
+		}
+		return resolvedAddresses{}, fmt.Errorf("resolution of %s is backing off until %s", host, r.nextAllowed)
+	}
+
+	resolved, ttl, err := r.lookup(ctx, host)
+	if err != nil {
+		r.backoff = nextBackoff(r.backoff)
+		r.nextAllowed = now.Add(r.backoff)
+		if r.haveConfirmed {
+			return r.confirmed, nil
+		}
+		return resolvedAddresses{}, err
+	}
+
+	r.backoff = 0
+	interval := r.minInterval
+	if ttl > interval {
+		interval = ttl
+	}
+	r.nextAllowed = now.Add(interval)
+
+	if !equalResolvedAddresses(resolved, r.pending) {
+		r.pending = resolved
+		r.pendingStreak = 1
+	} else {
+		r.pendingStreak++
+	}
+
+	// Publish immediately on the very first successful lookup; after that,
+	// a changed set must repeat DebounceResolves times in a row to stick.
+	if !r.haveConfirmed || r.pendingStreak >= r.debounceResolves {
+		r.confirmed = resolved
+		r.haveConfirmed = true
+	}
+
+	return r.confirmed, nil
+}
+
+// lookup performs a single DNS query, returning the resolved addresses and
+// the lowest non-zero TTL seen among the answer records (fallbackTTL when
+// the host's own resolver is used).
+func (r *dnsResolver) lookup(ctx context.Context, host string) (resolvedAddresses, time.Duration, error) {
+	if r.upstream == "" {
+		return lookupViaHostResolver(ctx, host)
+	}
+	return lookupViaUpstream(ctx, r.upstream, host)
+}
+
+func lookupViaHostResolver(ctx context.Context, host string) (resolvedAddresses, time.Duration, error) {
+	ipAddrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return resolvedAddresses{}, 0, err
+	}
+
+	var resolved resolvedAddresses
+	for _, ipAddr := range ipAddrs {
+		if ipAddr.IP.To4() != nil {
+			resolved.v4 = append(resolved.v4, ipAddr.IP.String())
+		} else {
+			resolved.v6 = append(resolved.v6, ipAddr.IP.String())
+		}
+	}
+	sort.Strings(resolved.v4)
+	sort.Strings(resolved.v6)
+
+	return resolved, fallbackTTL, nil
+}
+
+// lookupViaUpstream queries upstream for both A and AAAA records. The two
+// queries fail independently: a SERVFAIL/NXDOMAIN or transport error on one
+// qtype doesn't discard a successful answer for the other, since an
+// IPv6-less resolver intermittently failing AAAA is common and shouldn't
+// block publishing the IPv4 addresses that did resolve. An error is only
+// returned once both queries have failed.
+func lookupViaUpstream(ctx context.Context, upstream, host string) (resolvedAddresses, time.Duration, error) {
+	client := &dns.Client{}
+	fqdn := dns.Fqdn(host)
+	qtypes := []uint16{dns.TypeA, dns.TypeAAAA}
+
+	var resolved resolvedAddresses
+	minTTL := uint32(0)
+	var errs []error
+
+	for _, qtype := range qtypes {
+		msg := new(dns.Msg)
+		msg.SetQuestion(fqdn, qtype)
+
+		resp, _, err := client.ExchangeContext(ctx, msg, upstream)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s query for %s: %w", dns.TypeToString[qtype], host, err))
+			continue
+		}
+		if resp.Rcode == dns.RcodeNameError || resp.Rcode == dns.RcodeServerFailure {
+			errs = append(errs, fmt.Errorf("%s query for %s failed with rcode %s", dns.TypeToString[qtype], host, dns.RcodeToString[resp.Rcode]))
+			continue
+		}
+
+		for _, rr := range resp.Answer {
+			switch record := rr.(type) {
+			case *dns.A:
+				resolved.v4 = append(resolved.v4, record.A.String())
+				minTTL = minNonZeroTTL(minTTL, record.Hdr.Ttl)
+			case *dns.AAAA:
+				resolved.v6 = append(resolved.v6, record.AAAA.String())
+				minTTL = minNonZeroTTL(minTTL, record.Hdr.Ttl)
+			}
+		}
+	}
+
+	if len(errs) == len(qtypes) {
+		return resolvedAddresses{}, 0, errors.Join(errs...)
+	}
+
+	sort.Strings(resolved.v4)
+	sort.Strings(resolved.v6)
+
+	return resolved, time.Duration(minTTL) * time.Second, nil
+}
+
+func minNonZeroTTL(current, candidate uint32) uint32 {
+	if candidate == 0 {
+		return current
+	}
+	if current == 0 || candidate < current {
+		return candidate
+	}
+	return current
+}
+
+func nextBackoff(current time.Duration) time.Duration {
+	if current == 0 {
+		return minBackoff
+	}
+	next := current * 2
+	if next > maxBackoff {
+		return maxBackoff
+	}
+	return next
+}
+
+func equalResolvedAddresses(a, b resolvedAddresses) bool {
+	return equalAddresses(a.v4, b.v4) && equalAddresses(a.v6, b.v6)
+}
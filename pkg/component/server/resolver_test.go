@@ -0,0 +1,134 @@
+/*
+Copyright 2020 k0s authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// startFakeDNSServer starts a local UDP DNS server driven by handler and
+// returns its "host:port" address. It's torn down automatically at the end
+// of the test.
+func startFakeDNSServer(t *testing.T, handler dns.HandlerFunc) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	server := &dns.Server{PacketConn: pc, Handler: handler}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	t.Cleanup(func() {
+		assert.NoError(t, server.Shutdown())
+	})
+
+	return pc.LocalAddr().String()
+}
+
+func TestLookupViaUpstreamReturnsResolvedAddressesAndMinTTL(t *testing.T) {
+	upstream := startFakeDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+
+		var rr dns.RR
+		var err error
+		switch r.Question[0].Qtype {
+		case dns.TypeA:
+			rr, err = dns.NewRR(r.Question[0].Name + " 30 IN A 10.0.0.1")
+		case dns.TypeAAAA:
+			rr, err = dns.NewRR(r.Question[0].Name + " 60 IN AAAA 2001:db8::1")
+		}
+		assert.NoError(t, err)
+		m.Answer = append(m.Answer, rr)
+
+		assert.NoError(t, w.WriteMsg(m))
+	})
+
+	resolved, ttl, err := lookupViaUpstream(context.Background(), upstream, "example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1"}, resolved.v4)
+	assert.Equal(t, []string{"2001:db8::1"}, resolved.v6)
+	// The lower of the two records' TTLs (30s) must win.
+	assert.Equal(t, 30*time.Second, ttl)
+}
+
+func TestLookupViaUpstreamReturnsErrorOnNXDOMAIN(t *testing.T) {
+	upstream := startFakeDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeNameError)
+		assert.NoError(t, w.WriteMsg(m))
+	})
+
+	_, _, err := lookupViaUpstream(context.Background(), upstream, "nxdomain.example.com")
+	assert.Error(t, err)
+}
+
+func TestLookupViaUpstreamIgnoresZeroTTLWhenComputingMinTTL(t *testing.T) {
+	upstream := startFakeDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+
+		var rr dns.RR
+		var err error
+		switch r.Question[0].Qtype {
+		case dns.TypeA:
+			rr, err = dns.NewRR(r.Question[0].Name + " 30 IN A 10.0.0.1")
+		case dns.TypeAAAA:
+			// A zero TTL is common for CDN/GLB-fronted records and must not
+			// be allowed to stomp the real, nonzero TTL seen from the A query.
+			rr, err = dns.NewRR(r.Question[0].Name + " 0 IN AAAA 2001:db8::1")
+		}
+		assert.NoError(t, err)
+		m.Answer = append(m.Answer, rr)
+
+		assert.NoError(t, w.WriteMsg(m))
+	})
+
+	_, ttl, err := lookupViaUpstream(context.Background(), upstream, "example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, ttl)
+}
+
+func TestLookupViaUpstreamToleratesPartialFamilyFailure(t *testing.T) {
+	upstream := startFakeDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		if r.Question[0].Qtype == dns.TypeAAAA {
+			m.SetRcode(r, dns.RcodeServerFailure)
+			assert.NoError(t, w.WriteMsg(m))
+			return
+		}
+
+		m.SetReply(r)
+		rr, err := dns.NewRR(r.Question[0].Name + " 30 IN A 10.0.0.1")
+		assert.NoError(t, err)
+		m.Answer = append(m.Answer, rr)
+		assert.NoError(t, w.WriteMsg(m))
+	})
+
+	// SERVFAIL on the AAAA query alone must not discard the successfully
+	// resolved IPv4 answer.
+	resolved, _, err := lookupViaUpstream(context.Background(), upstream, "example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1"}, resolved.v4)
+	assert.Empty(t, resolved.v6)
+}
@@ -0,0 +1,30 @@
+/*
+Copyright 2020 k0s authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package leaderelection provides the leader election interface used by
+// components that must only run a single active instance across the
+// control plane.
+package leaderelection
+
+// Interface defines the leader election methods consumed by components
+// that need to know whether they're currently the active leader.
+type Interface interface {
+	Init() error
+	Run() error
+	Stop() error
+	Healthy() error
+	IsLeader() bool
+}